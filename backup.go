@@ -0,0 +1,273 @@
+package leveldb
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"io"
+
+	ds "github.com/ipfs/go-datastore"
+
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/iterator"
+	"github.com/syndtr/goleveldb/leveldb/util"
+)
+
+// backupMagic and backupVersion identify the stream format written by
+// Backup and read by Restore, so Restore can reject a stream written by an
+// incompatible future version instead of misinterpreting it.
+var backupMagic = [8]byte{'D', 'S', 'L', 'V', 'B', 'K', 'U', 'P'}
+
+const backupVersion = 1
+
+// endOfEntries is the key-length sentinel that terminates the entry
+// section of a backup stream and precedes the checksum trailer.
+const endOfEntries = 0xFFFFFFFF
+
+// ErrBackupVersion is returned by Restore when the stream's version header
+// doesn't match a version this package knows how to read.
+var ErrBackupVersion = errors.New("leveldb: unsupported backup stream version")
+
+// ErrBackupFormat is returned by Restore when the stream doesn't start
+// with the expected magic header.
+var ErrBackupFormat = errors.New("leveldb: not a leveldb backup stream")
+
+// ErrBackupChecksum is returned by Restore when the stream's trailing
+// checksum doesn't match its contents, meaning the stream was truncated or
+// corrupted in transit.
+var ErrBackupChecksum = errors.New("leveldb: backup checksum mismatch")
+
+// Backup writes a consistent, point-in-time copy of every key in d (across
+// the root instance and all configured Partitions) to w, as a stream of
+// length-prefixed key/value records in ascending key order, followed by a
+// CRC32 checksum trailer. The snapshot is taken once at the start of the
+// call, so concurrent writes to d are not reflected in the stream.
+//
+// If since is non-nil, only keys greater than since are written, letting
+// callers take incremental backups by passing the cursor returned from a
+// previous call. Backup streams its output via a k-way merge across
+// partitions rather than materializing the keyspace in memory, so its
+// memory use doesn't grow with the size of the store.
+func (d *Datastore) Backup(ctx context.Context, w io.Writer, since []byte) (cursor []byte, err error) {
+	if err := d.checkCtx(ctx); err != nil {
+		return nil, err
+	}
+
+	dbs := d.allDBs()
+	snaps := make([]*leveldb.Snapshot, 0, len(dbs))
+	defer func() {
+		for _, s := range snaps {
+			s.Release()
+		}
+	}()
+	for _, db := range dbs {
+		snap, err := db.GetSnapshot()
+		if err != nil {
+			return nil, err
+		}
+		snaps = append(snaps, snap)
+	}
+
+	var rnge *util.Range
+	if since != nil {
+		rnge = &util.Range{Start: append(append([]byte(nil), since...), 0x00)}
+	}
+	srcs := make([]*mergeSource, len(snaps))
+	for i, snap := range snaps {
+		srcs[i] = newMergeSource(snap.NewIterator(rnge, nil))
+	}
+	defer func() {
+		for _, s := range srcs {
+			s.it.Release()
+		}
+	}()
+
+	bw := bufio.NewWriter(w)
+	if _, err := bw.Write(backupMagic[:]); err != nil {
+		return nil, err
+	}
+	if err := bw.WriteByte(backupVersion); err != nil {
+		return nil, err
+	}
+
+	sum := crc32.NewIEEE()
+	body := io.MultiWriter(bw, sum)
+
+	for {
+		if err := ctx.Err(); err != nil {
+			return cursor, err
+		}
+		src := nextMergeSource(srcs)
+		if src == nil {
+			break
+		}
+		if err := writeBackupEntry(body, src.key(), src.value()); err != nil {
+			return cursor, err
+		}
+		cursor = append([]byte(nil), src.key()...)
+		src.advance()
+	}
+
+	var lenBuf [4]byte
+	binary.BigEndian.PutUint32(lenBuf[:], endOfEntries)
+	if _, err := body.Write(lenBuf[:]); err != nil {
+		return cursor, err
+	}
+	if _, err := bw.Write(sum.Sum(nil)); err != nil {
+		return cursor, err
+	}
+	return cursor, bw.Flush()
+}
+
+// Restore reads a stream written by Backup and applies it to d, restoring
+// each key exactly as it was backed up. It does not first clear d, so
+// restoring into a non-empty datastore merges the backup's keys with
+// whatever was already present, and a key present in both ends up with the
+// backed-up value. The checksum trailer is validated before anything is
+// written, so a corrupt or truncated stream is rejected without partially
+// applying it.
+func (d *Datastore) Restore(ctx context.Context, r io.Reader) (err error) {
+	if err := d.checkCtx(ctx); err != nil {
+		return err
+	}
+
+	// The checksum trailer comes after every entry, so it can only be
+	// validated once the whole stream has been read; r is buffered in full
+	// before anything is applied rather than applying entries as they
+	// stream in and risking a partially-restored datastore on a truncated
+	// or corrupt input.
+	var buf bytes.Buffer
+	if _, err := io.Copy(&buf, r); err != nil {
+		return err
+	}
+	data := buf.Bytes()
+
+	if len(data) < len(backupMagic)+1+4 {
+		return ErrBackupFormat
+	}
+	if !bytes.Equal(data[:len(backupMagic)], backupMagic[:]) {
+		return ErrBackupFormat
+	}
+	pos := len(backupMagic)
+	version := data[pos]
+	pos++
+	if version != backupVersion {
+		return ErrBackupVersion
+	}
+
+	bodyStart := pos
+	type entry struct{ key, value []byte }
+	var entries []entry
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if pos+4 > len(data) {
+			return ErrBackupFormat
+		}
+		klen := binary.BigEndian.Uint32(data[pos : pos+4])
+		pos += 4
+		if klen == endOfEntries {
+			break
+		}
+		if pos+int(klen) > len(data) {
+			return ErrBackupFormat
+		}
+		key := data[pos : pos+int(klen)]
+		pos += int(klen)
+
+		if pos+4 > len(data) {
+			return ErrBackupFormat
+		}
+		vlen := binary.BigEndian.Uint32(data[pos : pos+4])
+		pos += 4
+		if pos+int(vlen) > len(data) {
+			return ErrBackupFormat
+		}
+		value := data[pos : pos+int(vlen)]
+		pos += int(vlen)
+
+		entries = append(entries, entry{key: key, value: value})
+	}
+	bodyEnd := pos
+
+	if pos+4 > len(data) {
+		return ErrBackupFormat
+	}
+	wantSum := data[pos : pos+4]
+	sum := crc32.ChecksumIEEE(data[bodyStart:bodyEnd])
+	var gotSum [4]byte
+	binary.BigEndian.PutUint32(gotSum[:], sum)
+	if !bytes.Equal(wantSum, gotSum[:]) {
+		return ErrBackupChecksum
+	}
+
+	batch, err := d.Batch()
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := batch.Put(ctx, ds.NewKey(string(e.key)), e.value); err != nil {
+			return err
+		}
+	}
+	return batch.Commit(ctx)
+}
+
+func writeBackupEntry(w io.Writer, key, value []byte) error {
+	var lenBuf [4]byte
+	binary.BigEndian.PutUint32(lenBuf[:], uint32(len(key)))
+	if _, err := w.Write(lenBuf[:]); err != nil {
+		return err
+	}
+	if _, err := w.Write(key); err != nil {
+		return err
+	}
+	binary.BigEndian.PutUint32(lenBuf[:], uint32(len(value)))
+	if _, err := w.Write(lenBuf[:]); err != nil {
+		return err
+	}
+	if _, err := w.Write(value); err != nil {
+		return err
+	}
+	return nil
+}
+
+// mergeSource tracks one iterator's current position for nextMergeSource's
+// k-way merge: has reports whether key()/value() currently hold a valid
+// pair, which is true until the underlying iterator is exhausted.
+type mergeSource struct {
+	it  iterator.Iterator
+	has bool
+}
+
+func newMergeSource(it iterator.Iterator) *mergeSource {
+	return &mergeSource{it: it, has: it.Next()}
+}
+
+func (s *mergeSource) key() []byte   { return s.it.Key() }
+func (s *mergeSource) value() []byte { return s.it.Value() }
+func (s *mergeSource) advance()      { s.has = s.it.Next() }
+
+// nextMergeSource returns whichever source holds the smallest current key,
+// or nil once every source is exhausted. Every key in this package's
+// keyspace belongs to exactly one partition (see partitionFor), so distinct
+// sources never hold equal keys and there's no need to merge duplicates.
+func nextMergeSource(srcs []*mergeSource) *mergeSource {
+	var min *mergeSource
+	for _, s := range srcs {
+		if !s.has {
+			continue
+		}
+		if min == nil || bytes.Compare(s.key(), min.key()) < 0 {
+			min = s
+		}
+	}
+	return min
+}
@@ -0,0 +1,181 @@
+package leveldb
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	ds "github.com/ipfs/go-datastore"
+)
+
+func TestBackupRestoreRoundTrip(t *testing.T) {
+	ctx := context.Background()
+	src, doneSrc := newDSMem(t)
+	defer doneSrc()
+
+	want := map[string]string{
+		"/a": "1",
+		"/b": "2",
+		"/c": "3",
+	}
+	for k, v := range want {
+		if err := src.Put(ctx, ds.NewKey(k), []byte(v)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var buf bytes.Buffer
+	if _, err := src.Backup(ctx, &buf, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	dst, doneDst := newDSMem(t)
+	defer doneDst()
+	if err := dst.Restore(ctx, bytes.NewReader(buf.Bytes())); err != nil {
+		t.Fatal(err)
+	}
+
+	for k, v := range want {
+		got, err := dst.Get(ctx, ds.NewKey(k))
+		if err != nil || string(got) != v {
+			t.Fatalf("key %q: got %q, %v; want %q", k, got, err, v)
+		}
+	}
+}
+
+func TestBackupIsPointInTime(t *testing.T) {
+	ctx := context.Background()
+	d, done := newDSMem(t)
+	defer done()
+
+	if err := d.Put(ctx, ds.NewKey("/a"), []byte("before")); err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	if _, err := d.Backup(ctx, &buf, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// Mutate after the snapshot was taken; the backup already in buf must
+	// not observe it.
+	if err := d.Put(ctx, ds.NewKey("/a"), []byte("after")); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Put(ctx, ds.NewKey("/b"), []byte("new")); err != nil {
+		t.Fatal(err)
+	}
+
+	dst, doneDst := newDSMem(t)
+	defer doneDst()
+	if err := dst.Restore(ctx, bytes.NewReader(buf.Bytes())); err != nil {
+		t.Fatal(err)
+	}
+
+	if v, err := dst.Get(ctx, ds.NewKey("/a")); err != nil || string(v) != "before" {
+		t.Fatalf("got %q, %v; want %q", v, err, "before")
+	}
+	if has, err := dst.Has(ctx, ds.NewKey("/b")); err != nil || has {
+		t.Fatalf("expected /b absent from point-in-time backup, has=%v, err=%v", has, err)
+	}
+}
+
+func TestBackupIncrementalCursor(t *testing.T) {
+	ctx := context.Background()
+	d, done := newDSMem(t)
+	defer done()
+
+	if err := d.Put(ctx, ds.NewKey("/a"), []byte("1")); err != nil {
+		t.Fatal(err)
+	}
+
+	var full bytes.Buffer
+	cursor, err := d.Backup(ctx, &full, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := d.Put(ctx, ds.NewKey("/b"), []byte("2")); err != nil {
+		t.Fatal(err)
+	}
+
+	var incr bytes.Buffer
+	if _, err := d.Backup(ctx, &incr, cursor); err != nil {
+		t.Fatal(err)
+	}
+
+	dst, doneDst := newDSMem(t)
+	defer doneDst()
+	if err := dst.Restore(ctx, bytes.NewReader(full.Bytes())); err != nil {
+		t.Fatal(err)
+	}
+	if err := dst.Restore(ctx, bytes.NewReader(incr.Bytes())); err != nil {
+		t.Fatal(err)
+	}
+
+	if v, err := dst.Get(ctx, ds.NewKey("/a")); err != nil || string(v) != "1" {
+		t.Fatalf("got %q, %v", v, err)
+	}
+	if v, err := dst.Get(ctx, ds.NewKey("/b")); err != nil || string(v) != "2" {
+		t.Fatalf("got %q, %v", v, err)
+	}
+}
+
+func TestRestoreRejectsCorruptStream(t *testing.T) {
+	ctx := context.Background()
+	d, done := newDSMem(t)
+	defer done()
+	if err := d.Put(ctx, ds.NewKey("/a"), []byte("1")); err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	if _, err := d.Backup(ctx, &buf, nil); err != nil {
+		t.Fatal(err)
+	}
+	corrupt := buf.Bytes()
+	corrupt[len(corrupt)-1] ^= 0xFF
+
+	dst, doneDst := newDSMem(t)
+	defer doneDst()
+	if err := dst.Restore(ctx, bytes.NewReader(corrupt)); err != ErrBackupChecksum {
+		t.Fatalf("expected ErrBackupChecksum, got %v", err)
+	}
+	if has, err := dst.Has(ctx, ds.NewKey("/a")); err != nil || has {
+		t.Fatalf("expected nothing applied from a rejected stream, has=%v, err=%v", has, err)
+	}
+}
+
+func TestSnapshotAtIsPointInTime(t *testing.T) {
+	ctx := context.Background()
+	d, done := newDSMem(t)
+	defer done()
+
+	if err := d.Put(ctx, ds.NewKey("/a"), []byte("before")); err != nil {
+		t.Fatal(err)
+	}
+
+	snap, err := d.SnapshotAt(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer snap.Close()
+
+	if err := d.Put(ctx, ds.NewKey("/a"), []byte("after")); err != nil {
+		t.Fatal(err)
+	}
+
+	if v, err := snap.Get(ctx, ds.NewKey("/a")); err != nil || string(v) != "before" {
+		t.Fatalf("got %q, %v; want %q", v, err, "before")
+	}
+	if v, err := d.Get(ctx, ds.NewKey("/a")); err != nil || string(v) != "after" {
+		t.Fatalf("got %q, %v; want %q", v, err, "after")
+	}
+
+	if err := snap.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := snap.Get(ctx, ds.NewKey("/a")); err != ErrClosed {
+		t.Fatalf("expected ErrClosed after Close, got %v", err)
+	}
+}
@@ -0,0 +1,87 @@
+package leveldb
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	ds "github.com/ipfs/go-datastore"
+	dsq "github.com/ipfs/go-datastore/query"
+)
+
+func TestQueryRespectsCancel(t *testing.T) {
+	d, done := newDS(t)
+	defer done()
+
+	ctx := context.Background()
+	for n := 0; n < 1000; n++ {
+		if err := d.Put(ctx, ds.NewKey(fmt.Sprintf("/%d", n)), []byte("v")); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	qctx, cancel := context.WithCancel(context.Background())
+	rs, err := d.Query(qctx, dsq.Query{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Consume a single row, then cancel; the rest of the iteration should
+	// stop promptly with a context error instead of running to completion.
+	r, ok := rs.NextSync()
+	if !ok {
+		t.Fatal("expected at least one result before cancelling")
+	}
+	if r.Error != nil {
+		t.Fatal(r.Error)
+	}
+	cancel()
+
+	seen := 1
+	for {
+		r, ok := rs.NextSync()
+		if !ok {
+			break
+		}
+		if r.Error != nil {
+			if !errors.Is(r.Error, context.Canceled) {
+				t.Fatalf("expected context.Canceled, got %v", r.Error)
+			}
+			break
+		}
+		seen++
+	}
+	if seen >= 1000 {
+		t.Fatal("query did not stop after context was cancelled")
+	}
+}
+
+func TestPutRejectsCancelledContext(t *testing.T) {
+	d, done := newDS(t)
+	defer done()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if err := d.Put(ctx, ds.NewKey("/a"), []byte("a")); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+}
+
+func TestDiskUsageRespectsCancelledContext(t *testing.T) {
+	d, done := newDS(t)
+	defer done()
+
+	ctx := context.Background()
+	if err := d.Put(ctx, ds.NewKey("/a"), []byte("a")); err != nil {
+		t.Fatal(err)
+	}
+
+	cctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if _, err := d.DiskUsage(cctx); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+}
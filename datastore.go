@@ -0,0 +1,327 @@
+package leveldb
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	ds "github.com/ipfs/go-datastore"
+	dsq "github.com/ipfs/go-datastore/query"
+
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/opt"
+	"github.com/syndtr/goleveldb/leveldb/storage"
+	"github.com/syndtr/goleveldb/leveldb/util"
+)
+
+// ErrClosed is returned from Datastore operations (other than DiskUsage)
+// performed after Close has been called, and from operations on a Txn or
+// Snapshot after that Txn or Snapshot has itself been closed. A Txn or Batch
+// created before its Datastore was closed doesn't check the Datastore's
+// closed state; whatever it sends to the closed LevelDB instance fails with
+// goleveldb's own error instead.
+var ErrClosed = errors.New("datastore closed")
+
+// Datastore is backed by a LevelDB instance. It implements the full
+// ds.Datastore, ds.Batching, ds.PersistentDatastore and ds.TxnDatastore
+// interfaces from go-datastore.
+type Datastore struct {
+	path string
+	db   *leveldb.DB
+
+	// partitions holds one entry per configured Options.Partitions,
+	// sorted longest-prefix-first. Keys/queries that don't match any of
+	// them fall back to db. See partition.go.
+	partitions []partitionDB
+
+	// rootLog tracks commits to db for transaction conflict detection, the
+	// same way each partition's own wlog does for it. See writelog.go.
+	rootLog *writeLog
+
+	// closeLk guards closed. Operations check it up-front via checkClosed
+	// and don't hold it while they run, so Close can proceed while an
+	// operation that already passed the check is still in flight; that
+	// operation then runs against the closed LevelDB instance.
+	closeLk sync.RWMutex
+	closed  bool
+
+	metricsLk sync.RWMutex
+	metrics   *metrics
+}
+
+// Options configures a Datastore. It embeds goleveldb's own Options so
+// existing tuning knobs (cache size, compression, ...) keep working
+// unchanged, and adds this package's own extensions.
+type Options struct {
+	opt.Options
+
+	// Partitions declares key-prefix partitions stored in separate
+	// underlying LevelDB instances alongside the root one. See Partition.
+	Partitions []Partition
+}
+
+var _ ds.Datastore = (*Datastore)(nil)
+var _ ds.Batching = (*Datastore)(nil)
+var _ ds.PersistentDatastore = (*Datastore)(nil)
+var _ ds.TxnDatastore = (*Datastore)(nil)
+
+// NewDatastore returns a new datastore backed by leveldb
+//
+// for path == "", an in memory backend will be chosen
+func NewDatastore(path string, opts *Options) (*Datastore, error) {
+	var nopts opt.Options
+	var partitionOpts []Partition
+	if opts != nil {
+		nopts = opts.Options
+		partitionOpts = opts.Partitions
+	}
+
+	var store storage.Storage
+	var err error
+	if path == "" {
+		store = storage.NewMemStorage()
+	} else {
+		store, err = storage.OpenFile(path, false)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	db, err := leveldb.Open(store, &nopts)
+	if err != nil {
+		return nil, err
+	}
+
+	partitions, err := openPartitions(path, partitionOpts)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return &Datastore{
+		path:       path,
+		db:         db,
+		partitions: partitions,
+		rootLog:    newWriteLog(),
+	}, nil
+}
+
+func (d *Datastore) checkClosed() error {
+	d.closeLk.RLock()
+	defer d.closeLk.RUnlock()
+	if d.closed {
+		return ErrClosed
+	}
+	return nil
+}
+
+// checkCtx rejects the operation up-front if ctx has already been
+// cancelled or the datastore has been closed.
+func (d *Datastore) checkCtx(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return d.checkClosed()
+}
+
+// Put implements ds.Datastore.
+func (d *Datastore) Put(ctx context.Context, key ds.Key, value []byte) (err error) {
+	defer d.track("put", time.Now(), &err, len(key.Bytes()), len(value))
+	if err = d.checkCtx(ctx); err != nil {
+		return err
+	}
+	db := d.partitionFor(key)
+	err = d.writeLocked(db, []string{key.String()}, func() error {
+		return db.Put(key.Bytes(), value, nil)
+	})
+	return err
+}
+
+// Sync implements ds.Datastore. LevelDB commits every write immediately
+// (WriteOptions.Sync is not enabled by default), so there is nothing to do
+// beyond making sure the datastore is still open and the context is live.
+func (d *Datastore) Sync(ctx context.Context, prefix ds.Key) error {
+	return d.checkCtx(ctx)
+}
+
+// Get implements ds.Datastore.
+func (d *Datastore) Get(ctx context.Context, key ds.Key) (value []byte, err error) {
+	start := time.Now()
+	defer func() { d.track("get", start, &err, len(key.Bytes()), len(value)) }()
+	if err = d.checkCtx(ctx); err != nil {
+		return nil, err
+	}
+	value, err = d.partitionFor(key).Get(key.Bytes(), nil)
+	if err != nil {
+		if err == leveldb.ErrNotFound {
+			err = ds.ErrNotFound
+		}
+		return nil, err
+	}
+	return value, nil
+}
+
+// Has implements ds.Datastore.
+func (d *Datastore) Has(ctx context.Context, key ds.Key) (exists bool, err error) {
+	defer d.track("has", time.Now(), &err, len(key.Bytes()), -1)
+	if err = d.checkCtx(ctx); err != nil {
+		return false, err
+	}
+	exists, err = d.partitionFor(key).Has(key.Bytes(), nil)
+	return exists, err
+}
+
+// GetSize implements ds.Datastore.
+func (d *Datastore) GetSize(ctx context.Context, key ds.Key) (size int, err error) {
+	if err := d.checkCtx(ctx); err != nil {
+		return -1, err
+	}
+	return ds.GetBackedSize(ctx, d, key)
+}
+
+// Delete implements ds.Datastore.
+func (d *Datastore) Delete(ctx context.Context, key ds.Key) (err error) {
+	defer d.track("delete", time.Now(), &err, len(key.Bytes()), -1)
+	if err = d.checkCtx(ctx); err != nil {
+		return err
+	}
+	db := d.partitionFor(key)
+	err = d.writeLocked(db, []string{key.String()}, func() error {
+		return db.Delete(key.Bytes(), nil)
+	})
+	return err
+}
+
+// Query implements ds.Datastore. With no Partitions configured it scans the
+// single underlying LevelDB directly; with Partitions configured, a Prefix
+// that resolves to one partition is dispatched there, and any other query
+// (prefix-less, or with a Prefix that also covers a more specific
+// partition) fans out across the root instance and every partition and
+// merges the results. See partition.go.
+func (d *Datastore) Query(ctx context.Context, q dsq.Query) (_ dsq.Results, err error) {
+	defer d.track("query", time.Now(), &err, -1, -1)
+	if err = d.checkCtx(ctx); err != nil {
+		return nil, err
+	}
+
+	if len(d.partitions) == 0 {
+		return d.queryReader(ctx, d.db, q)
+	}
+	if target, ok := d.partitionForPrefix(q.Prefix); ok {
+		return d.queryReader(ctx, target, q)
+	}
+	return d.queryFanOut(ctx, q)
+}
+
+// queryReader runs q against a single LevelDB-like reader (the root
+// instance or one partition). It pushes Prefix down to the iterator range
+// and applies everything else (orders, filters, offset, limit) with the
+// naive in-memory helpers from go-datastore/query. The underlying iterator
+// checks ctx between rows, so a cancelled or timed-out ctx aborts the scan
+// promptly, ending the results with one Result carrying ctx's error; this
+// doesn't apply to queries with an Order, since sorting requires
+// materializing the full result set up front.
+func (d *Datastore) queryReader(ctx context.Context, r leveldbReader, q dsq.Query) (dsq.Results, error) {
+	var rnge *util.Range
+	if q.Prefix != "" {
+		prefix := ds.NewKey(q.Prefix).String()
+		if prefix != "/" {
+			rnge = util.BytesPrefix([]byte(prefix + "/"))
+		}
+	}
+
+	iter := r.NewIterator(rnge, nil)
+	if m := d.currentMetrics(); m != nil {
+		m.openIterators.Inc()
+	}
+	closeIter := func() error {
+		iter.Release()
+		if m := d.currentMetrics(); m != nil {
+			m.openIterators.Dec()
+		}
+		return iter.Error()
+	}
+
+	// The prefix has already been applied via the iterator range; clear it
+	// so NaiveQueryApply doesn't try (and fail) to re-filter on it.
+	naive := q
+	naive.Prefix = ""
+
+	var ctxErrSent bool
+	results := dsq.ResultsFromIterator(q, dsq.Iterator{
+		Next: func() (dsq.Result, bool) {
+			if err := ctx.Err(); err != nil {
+				if ctxErrSent {
+					return dsq.Result{}, false
+				}
+				ctxErrSent = true
+				return dsq.Result{Error: err}, true
+			}
+			if !iter.Next() {
+				return dsq.Result{}, false
+			}
+			e := dsq.Entry{Key: string(iter.Key()), Size: len(iter.Value())}
+			if !q.KeysOnly {
+				buf := make([]byte, len(iter.Value()))
+				copy(buf, iter.Value())
+				e.Value = buf
+			}
+			return dsq.Result{Entry: e}, true
+		},
+		Close: closeIter,
+	})
+	return dsq.NaiveQueryApply(naive, results), nil
+}
+
+// DiskUsage implements ds.PersistentDatastore. It returns 0 for in-memory
+// datastores, since there's no path to walk. The directory walk checks ctx
+// between entries so it can be aborted before completing on a large store.
+func (d *Datastore) DiskUsage(ctx context.Context) (uint64, error) {
+	if d.path == "" {
+		return 0, nil
+	}
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	var du uint64
+	err := filepath.Walk(d.path, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if !info.IsDir() {
+			du += uint64(info.Size())
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return du, nil
+}
+
+// Close closes the underlying LevelDB and every partition. It is safe to
+// call more than once. If closing a partition fails, Close still attempts
+// to close the rest and returns the first error encountered.
+func (d *Datastore) Close() (err error) {
+	d.closeLk.Lock()
+	defer d.closeLk.Unlock()
+	if d.closed {
+		return nil
+	}
+	d.closed = true
+
+	err = d.db.Close()
+	for _, p := range d.partitions {
+		if perr := p.db.Close(); err == nil {
+			err = perr
+		}
+	}
+	return err
+}
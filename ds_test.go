@@ -50,13 +50,21 @@ func newDS(t *testing.T) (*Datastore, func()) {
 	}
 }
 
-// newDSMem returns an in-memory datastore.
-func newDSMem(t *testing.T) *Datastore {
+// returns an in-memory datastore, and a function to call on exit.
+// (this garbage collects). So:
+//
+//  d, close := newDSMem(t)
+//  defer close()
+func newDSMem(t *testing.T) (*Datastore, func()) {
 	d, err := NewDatastore("", nil)
 	if err != nil {
 		t.Fatal(err)
 	}
-	return d
+	return d, func() {
+		if err := d.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
 }
 
 func addTestCases(t *testing.T, ctx context.Context, d *Datastore, testcases map[string]string) {
@@ -144,7 +152,8 @@ func TestQuery(t *testing.T) {
 	testQuery(t, d)
 }
 func TestQueryMem(t *testing.T) {
-	d := newDSMem(t)
+	d, done := newDSMem(t)
+	defer done()
 	testQuery(t, d)
 }
 
@@ -201,7 +210,8 @@ func TestCloseSafety(t *testing.T) {
 func TestQueryRespectsProcessMem(t *testing.T) {
 	ctx := context.Background()
 
-	d := newDSMem(t)
+	d, done := newDSMem(t)
+	defer done()
 	addTestCases(t, ctx, d, testcases)
 }
 
@@ -284,7 +294,8 @@ func TestBatching(t *testing.T) {
 }
 
 func TestBatchingMem(t *testing.T) {
-	d := newDSMem(t)
+	d, done := newDSMem(t)
+	defer done()
 	testBatching(t, d)
 }
 
@@ -293,7 +304,7 @@ func TestDiskUsage(t *testing.T) {
 
 	d, done := newDS(t)
 	addTestCases(t, ctx, d, testcases)
-	du, err := d.DiskUsage()
+	du, err := d.DiskUsage(ctx)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -308,7 +319,7 @@ func TestDiskUsage(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	du2, err := d.DiskUsage()
+	du2, err := d.DiskUsage(ctx)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -319,15 +330,16 @@ func TestDiskUsage(t *testing.T) {
 	done()
 
 	// This should fail
-	_, err = d.DiskUsage()
+	_, err = d.DiskUsage(ctx)
 	if err == nil {
 		t.Fatal("DiskUsage should fail when we cannot walk path")
 	}
 }
 
 func TestDiskUsageInMem(t *testing.T) {
-	d := newDSMem(t)
-	du, _ := d.DiskUsage()
+	d, done := newDSMem(t)
+	defer done()
+	du, _ := d.DiskUsage(context.Background())
 	if du != 0 {
 		t.Fatal("inmem dbs have 0 disk usage")
 	}
@@ -435,7 +447,7 @@ func TestTransactionManyOperations(t *testing.T) {
 }
 
 func TestSuite(t *testing.T) {
-	d := newDSMem(t)
-	defer d.Close()
+	d, done := newDSMem(t)
+	defer done()
 	dstest.SubtestAll(t, d)
 }
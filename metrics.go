@@ -0,0 +1,246 @@
+package leveldb
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// MetricsRecorder is a generic, Prometheus-agnostic observability hook.
+// Callers instrumenting with something other than Prometheus (OpenTelemetry,
+// for example) can implement it and install it with SetMetricsRecorder
+// instead of (or alongside) RegisterMetrics.
+type MetricsRecorder interface {
+	// RecordOp is called once every Put/Get/Has/Delete/Query/Batch/Transaction
+	// operation finishes, with the op name ("put", "get", "query", ...), how
+	// long it took, and the error it returned (nil on success).
+	RecordOp(op string, dur time.Duration, err error)
+	// RecordSize is called for operations that carry a key and/or a value.
+	// valueSize is -1 when the operation has no value (e.g. Has, Delete).
+	RecordSize(op string, keySize, valueSize int)
+}
+
+const metricsNamespace = "ds_leveldb"
+
+// metrics holds the Prometheus collectors and/or generic recorder for a
+// Datastore. A nil *metrics means instrumentation hasn't been enabled;
+// track and Query check for nil before touching it, so the hot path only
+// pays for a nil check.
+type metrics struct {
+	opDuration *prometheus.HistogramVec
+	opTotal    *prometheus.CounterVec
+	opErrors   *prometheus.CounterVec
+	keySize    *prometheus.HistogramVec
+	valueSize  *prometheus.HistogramVec
+
+	compactionCount prometheus.Gauge
+	cacheHitRatio   prometheus.Gauge
+	openIterators   prometheus.Gauge
+	diskUsageBytes  prometheus.Gauge
+
+	recorder MetricsRecorder
+}
+
+func newMetrics() *metrics {
+	return &metrics{
+		opDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: metricsNamespace,
+			Name:      "op_duration_seconds",
+			Help:      "Latency of Datastore operations, by op.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"op"}),
+		opTotal: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "op_total",
+			Help:      "Number of Datastore operations, by op.",
+		}, []string{"op"}),
+		opErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "op_errors_total",
+			Help:      "Number of Datastore operations that returned an error, by op.",
+		}, []string{"op"}),
+		keySize: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: metricsNamespace,
+			Name:      "key_size_bytes",
+			Help:      "Size of keys passed to Datastore operations, by op.",
+			Buckets:   prometheus.ExponentialBuckets(8, 2, 10),
+		}, []string{"op"}),
+		valueSize: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: metricsNamespace,
+			Name:      "value_size_bytes",
+			Help:      "Size of values passed to Datastore operations, by op.",
+			Buckets:   prometheus.ExponentialBuckets(32, 2, 14),
+		}, []string{"op"}),
+		compactionCount: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: metricsNamespace,
+			Name:      "compactions_total",
+			Help:      "Number of LevelDB compactions observed so far, parsed from leveldb.stats.",
+		}),
+		cacheHitRatio: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: metricsNamespace,
+			Name:      "block_cache_hit_ratio",
+			Help:      "LevelDB block cache hit ratio, parsed from leveldb.stats. Best-effort: 0 if unavailable.",
+		}),
+		openIterators: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: metricsNamespace,
+			Name:      "open_iterators",
+			Help:      "Number of Query iterators currently open.",
+		}),
+		diskUsageBytes: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: metricsNamespace,
+			Name:      "disk_usage_bytes",
+			Help:      "On-disk size of the datastore, as last measured by DiskUsage.",
+		}),
+	}
+}
+
+// collectors returns the collectors whose value is always current (cheap
+// counters/histograms updated inline by track, plus openIterators which is
+// inc/dec'd directly by Query). The LevelDB-specific gauges are registered
+// separately, wrapped in a levelDBStatsCollector, since refreshing them
+// costs a DiskUsage walk: see levelDBStatsCollector.
+func (m *metrics) collectors() []prometheus.Collector {
+	return []prometheus.Collector{
+		m.opDuration, m.opTotal, m.opErrors, m.keySize, m.valueSize, m.openIterators,
+	}
+}
+
+// levelDBStatsCollector refreshes the LevelDB-specific gauges (compaction
+// count, cache hit ratio, disk usage) only when Prometheus scrapes them,
+// rather than on every write. DiskUsage walks the whole datastore
+// directory tree (and, with Partitions configured, every partition's
+// subdirectory too), so recomputing it synchronously inside Put/Delete/
+// Batch.Commit/Transaction.Commit would turn "opt-in instrumentation"
+// into an O(files-on-disk) cost per write.
+type levelDBStatsCollector struct {
+	d *Datastore
+	m *metrics
+}
+
+func (c *levelDBStatsCollector) Describe(ch chan<- *prometheus.Desc) {
+	c.m.compactionCount.Describe(ch)
+	c.m.cacheHitRatio.Describe(ch)
+	c.m.diskUsageBytes.Describe(ch)
+}
+
+func (c *levelDBStatsCollector) Collect(ch chan<- prometheus.Metric) {
+	if stats, err := c.d.db.GetProperty("leveldb.stats"); err == nil {
+		compactions, hitRatio := parseLevelDBStats(stats)
+		c.m.compactionCount.Set(compactions)
+		c.m.cacheHitRatio.Set(hitRatio)
+	}
+	if du, err := c.d.DiskUsage(context.Background()); err == nil {
+		c.m.diskUsageBytes.Set(float64(du))
+	}
+	c.m.compactionCount.Collect(ch)
+	c.m.cacheHitRatio.Collect(ch)
+	c.m.diskUsageBytes.Collect(ch)
+}
+
+// RegisterMetrics turns on Prometheus instrumentation for d and registers
+// its collectors with reg. It is safe to call RegisterMetrics and
+// SetMetricsRecorder together; both will observe every operation.
+func (d *Datastore) RegisterMetrics(reg prometheus.Registerer) error {
+	d.metricsLk.Lock()
+	defer d.metricsLk.Unlock()
+
+	m := newMetrics()
+	if d.metrics != nil {
+		m.recorder = d.metrics.recorder
+	}
+	for _, c := range m.collectors() {
+		if err := reg.Register(c); err != nil {
+			return err
+		}
+	}
+	if err := reg.Register(&levelDBStatsCollector{d: d, m: m}); err != nil {
+		return err
+	}
+	d.metrics = m
+	return nil
+}
+
+// SetMetricsRecorder installs a MetricsRecorder for callers that aren't on
+// Prometheus. Safe to call whether or not RegisterMetrics has been called.
+func (d *Datastore) SetMetricsRecorder(r MetricsRecorder) {
+	d.metricsLk.Lock()
+	defer d.metricsLk.Unlock()
+	if d.metrics == nil {
+		d.metrics = newMetrics()
+	}
+	d.metrics.recorder = r
+}
+
+func (d *Datastore) currentMetrics() *metrics {
+	d.metricsLk.RLock()
+	defer d.metricsLk.RUnlock()
+	return d.metrics
+}
+
+// track times op via a defer in the caller: `defer d.track("put", time.Now(), &err, len(key), len(value))`.
+// keySize/valueSize of -1 means "not applicable" and skips that histogram.
+func (d *Datastore) track(op string, start time.Time, err *error, keySize, valueSize int) {
+	m := d.currentMetrics()
+	if m == nil {
+		return
+	}
+
+	dur := time.Since(start)
+	var opErr error
+	if err != nil {
+		opErr = *err
+	}
+
+	m.opDuration.WithLabelValues(op).Observe(dur.Seconds())
+	m.opTotal.WithLabelValues(op).Inc()
+	if opErr != nil {
+		m.opErrors.WithLabelValues(op).Inc()
+	}
+	if keySize >= 0 {
+		m.keySize.WithLabelValues(op).Observe(float64(keySize))
+	}
+	if valueSize >= 0 {
+		m.valueSize.WithLabelValues(op).Observe(float64(valueSize))
+	}
+
+	if m.recorder != nil {
+		m.recorder.RecordOp(op, dur, opErr)
+		if keySize >= 0 || valueSize >= 0 {
+			m.recorder.RecordSize(op, keySize, valueSize)
+		}
+	}
+}
+
+// parseLevelDBStats does a best-effort parse of the "leveldb.stats"
+// property, which is a human-readable table intended for debugging and has
+// no stability guarantees across goleveldb versions. It returns the total
+// compaction count summed across levels, and the block cache hit ratio if
+// the property happens to report cache statistics; both are 0 if the
+// format isn't recognized.
+func parseLevelDBStats(stats string) (compactions float64, cacheHitRatio float64) {
+	var cacheHits, cacheMisses float64
+	for _, line := range strings.Split(stats, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		switch {
+		case len(fields) >= 2 && fields[0] == "CacheHits":
+			cacheHits, _ = strconv.ParseFloat(fields[1], 64)
+		case len(fields) >= 2 && fields[0] == "CacheMisses":
+			cacheMisses, _ = strconv.ParseFloat(fields[1], 64)
+		default:
+			// Level rows look like "N | tables | size | time | read | write".
+			if n, err := strconv.Atoi(fields[0]); err == nil && n >= 0 {
+				compactions++
+			}
+		}
+	}
+	if cacheHits+cacheMisses > 0 {
+		cacheHitRatio = cacheHits / (cacheHits + cacheMisses)
+	}
+	return compactions, cacheHitRatio
+}
@@ -0,0 +1,244 @@
+package leveldb
+
+import (
+	"context"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	ds "github.com/ipfs/go-datastore"
+	dsq "github.com/ipfs/go-datastore/query"
+
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/iterator"
+	"github.com/syndtr/goleveldb/leveldb/opt"
+	"github.com/syndtr/goleveldb/leveldb/storage"
+	"github.com/syndtr/goleveldb/leveldb/util"
+)
+
+// Partition declares a key-prefix namespace that is stored in its own
+// underlying LevelDB instance, separate from the root instance and from
+// other partitions. This lets operators isolate compaction pressure and
+// tune caches independently for different workload classes (e.g. "/blocks"
+// vs "/pins" vs "/system") while still addressing everything through a
+// single Datastore.
+type Partition struct {
+	// Prefix is the ds.Key prefix routed to this partition, e.g. "/blocks".
+	Prefix string
+	// Options configures the partition's own LevelDB instance. A nil
+	// Options uses goleveldb's defaults. Options.Partitions is ignored:
+	// partitions don't nest.
+	Options *Options
+}
+
+// partitionDB is a resolved Partition: its LevelDB instance plus its
+// normalized prefix, used for routing.
+type partitionDB struct {
+	prefix string // ds.Key-normalized, e.g. "/blocks"
+	db     *leveldb.DB
+	wlog   *writeLog
+}
+
+// leveldbReader is the subset of *leveldb.DB and *leveldb.Snapshot that
+// Query needs, so the same scanning code can run against either.
+type leveldbReader interface {
+	NewIterator(slice *util.Range, ro *opt.ReadOptions) iterator.Iterator
+}
+
+// openPartitions opens one LevelDB instance per Partition, in a
+// subdirectory of path named after its (sanitized) prefix. For an in-memory
+// root datastore (path == ""), partitions are in-memory too.
+func openPartitions(path string, parts []Partition) ([]partitionDB, error) {
+	if len(parts) == 0 {
+		return nil, nil
+	}
+
+	dbs := make([]partitionDB, 0, len(parts))
+	for _, part := range parts {
+		prefix := ds.NewKey(part.Prefix).String()
+
+		var nopts opt.Options
+		if part.Options != nil {
+			nopts = part.Options.Options
+		}
+
+		var store storage.Storage
+		var err error
+		if path == "" {
+			store = storage.NewMemStorage()
+		} else {
+			store, err = storage.OpenFile(filepath.Join(path, partitionDirName(prefix)), false)
+			if err != nil {
+				closePartitions(dbs)
+				return nil, err
+			}
+		}
+
+		db, err := leveldb.Open(store, &nopts)
+		if err != nil {
+			closePartitions(dbs)
+			return nil, err
+		}
+		dbs = append(dbs, partitionDB{prefix: prefix, db: db, wlog: newWriteLog()})
+	}
+
+	// Longest prefix first, so routing always matches the most specific
+	// partition when prefixes happen to nest (e.g. "/blocks" and
+	// "/blocks/cold").
+	sort.Slice(dbs, func(i, j int) bool { return len(dbs[i].prefix) > len(dbs[j].prefix) })
+	return dbs, nil
+}
+
+func closePartitions(dbs []partitionDB) {
+	for _, p := range dbs {
+		p.db.Close()
+	}
+}
+
+// partitionDirName turns a normalized ds.Key prefix like "/blocks/cold"
+// into a filesystem-safe directory name. "/" becomes "-", while a literal
+// "-" (and the "%" escape character itself) is percent-escaped, so two
+// prefixes can never collide just because one's "/" lines up with
+// another's "-" (e.g. "/ab/cd" and "/ab-cd" produce "partition-ab-cd" and
+// "partition-ab%2Dcd" respectively, not the same name twice).
+func partitionDirName(prefix string) string {
+	r := strings.NewReplacer("%", "%25", "-", "%2D", "/", "-")
+	return "partition-" + r.Replace(strings.Trim(prefix, "/"))
+}
+
+// partitionFor returns the LevelDB instance that owns key: the most
+// specific configured Partition whose prefix matches, or the root
+// instance if none do.
+func (d *Datastore) partitionFor(key ds.Key) *leveldb.DB {
+	ks := key.String()
+	for _, p := range d.partitions {
+		if ks == p.prefix || strings.HasPrefix(ks, p.prefix+"/") {
+			return p.db
+		}
+	}
+	return d.db
+}
+
+// logFor returns the writeLog tracking commits to db, which is either the
+// root instance's or the matching partition's.
+func (d *Datastore) logFor(db *leveldb.DB) *writeLog {
+	if db == d.db {
+		return d.rootLog
+	}
+	for _, p := range d.partitions {
+		if p.db == db {
+			return p.wlog
+		}
+	}
+	// Unreachable with routing helpers that only ever hand out d.db or a
+	// partition's db, but fall back to the root log rather than panicking.
+	return d.rootLog
+}
+
+// writeLocked runs apply (a Put, Delete or Batch.Write against db) and, if
+// it succeeds, records keys as committed in db's writeLog, all under that
+// log's commit lock. This is the "write lock" that transaction Commits
+// re-check their read set under, so every write that isn't part of a
+// transaction still has to go through here for conflict detection to see
+// it.
+func (d *Datastore) writeLocked(db *leveldb.DB, keys []string, apply func() error) error {
+	wl := d.logFor(db)
+	wl.lockCommit()
+	defer wl.unlockCommit()
+
+	if err := apply(); err != nil {
+		return err
+	}
+	if len(keys) > 0 {
+		wl.record(keys)
+	}
+	return nil
+}
+
+// allDBs returns the root instance and every configured partition's
+// instance, in the order Backup and SnapshotAt use to build their
+// per-store snapshots.
+func (d *Datastore) allDBs() []*leveldb.DB {
+	dbs := make([]*leveldb.DB, 0, len(d.partitions)+1)
+	dbs = append(dbs, d.db)
+	for _, p := range d.partitions {
+		dbs = append(dbs, p.db)
+	}
+	return dbs
+}
+
+// dbOrder returns a fixed, total order over every instance this Datastore
+// owns (the same order allDBs reports), so that code locking more than one
+// partition's writeLog at once can always do so in the same global order
+// and avoid deadlocking against another caller locking the same set.
+func (d *Datastore) dbOrder() map[*leveldb.DB]int {
+	dbs := d.allDBs()
+	order := make(map[*leveldb.DB]int, len(dbs))
+	for i, db := range dbs {
+		order[db] = i
+	}
+	return order
+}
+
+// partitionForPrefix returns the single LevelDB instance that can answer a
+// Query with the given dsq.Query.Prefix, routing it exactly like a key of
+// that value. ok is false for a prefix-less query ("" or "/"), and for a
+// prefix that some more specific partition's prefix lies under (e.g.
+// "/blocks" when "/blocks/cold" is configured), since no single instance
+// holds all its keys; such a query must fan out across every instance
+// instead; see Datastore.Query.
+func (d *Datastore) partitionForPrefix(prefix string) (r leveldbReader, ok bool) {
+	if prefix == "" || prefix == "/" {
+		return nil, false
+	}
+	pk := ds.NewKey(prefix).String()
+	for _, p := range d.partitions {
+		if strings.HasPrefix(p.prefix, pk+"/") {
+			return nil, false
+		}
+	}
+	return d.partitionFor(ds.NewKey(prefix)), true
+}
+
+// queryFanOut runs a query across the root instance and every
+// partition, then merges and re-applies the query's orders/filters/
+// offset/limit globally with go-datastore's naive helpers. Unlike
+// queryReader, this always materializes the full result set up front,
+// since a correct merge sort (or correct offset/limit) needs every
+// instance's contribution before anything can be returned.
+func (d *Datastore) queryFanOut(ctx context.Context, q dsq.Query) (dsq.Results, error) {
+	dbs := d.allDBs()
+	readers := make([]leveldbReader, len(dbs))
+	for i, db := range dbs {
+		readers[i] = db
+	}
+
+	var entries []dsq.Entry
+	for _, r := range readers {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		iter := r.NewIterator(nil, nil)
+		for iter.Next() {
+			if err := ctx.Err(); err != nil {
+				iter.Release()
+				return nil, err
+			}
+			e := dsq.Entry{Key: string(iter.Key()), Size: len(iter.Value())}
+			if !q.KeysOnly {
+				buf := make([]byte, len(iter.Value()))
+				copy(buf, iter.Value())
+				e.Value = buf
+			}
+			entries = append(entries, e)
+		}
+		err := iter.Error()
+		iter.Release()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return dsq.NaiveQueryApply(q, dsq.ResultsWithEntries(q, entries)), nil
+}
@@ -0,0 +1,132 @@
+package leveldb
+
+import (
+	"context"
+	"testing"
+
+	ds "github.com/ipfs/go-datastore"
+	dsq "github.com/ipfs/go-datastore/query"
+)
+
+func newPartitionedDS(t *testing.T) (*Datastore, func()) {
+	t.Helper()
+	d, err := NewDatastore("", &Options{
+		Partitions: []Partition{
+			{Prefix: "/blocks"},
+			{Prefix: "/pins"},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return d, func() {
+		if err := d.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestPartitionRouting(t *testing.T) {
+	ctx := context.Background()
+	d, done := newPartitionedDS(t)
+	defer done()
+
+	if err := d.Put(ctx, ds.NewKey("/blocks/a"), []byte("block-a")); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Put(ctx, ds.NewKey("/pins/a"), []byte("pin-a")); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Put(ctx, ds.NewKey("/system/a"), []byte("sys-a")); err != nil {
+		t.Fatal(err)
+	}
+
+	if v, err := d.Get(ctx, ds.NewKey("/blocks/a")); err != nil || string(v) != "block-a" {
+		t.Fatalf("got %q, %v", v, err)
+	}
+	if v, err := d.Get(ctx, ds.NewKey("/pins/a")); err != nil || string(v) != "pin-a" {
+		t.Fatalf("got %q, %v", v, err)
+	}
+	if v, err := d.Get(ctx, ds.NewKey("/system/a")); err != nil || string(v) != "sys-a" {
+		t.Fatalf("got %q, %v", v, err)
+	}
+
+	if dbs := d.partitionFor(ds.NewKey("/blocks/a")); dbs == d.db {
+		t.Fatal("expected /blocks/a to route to the blocks partition, not the root db")
+	}
+	if dbs := d.partitionFor(ds.NewKey("/system/a")); dbs != d.db {
+		t.Fatal("expected /system/a to route to the root db")
+	}
+}
+
+func TestPartitionPrefixQuery(t *testing.T) {
+	ctx := context.Background()
+	d, done := newPartitionedDS(t)
+	defer done()
+
+	addTestCases(t, ctx, d, map[string]string{
+		"/blocks/a": "1",
+		"/blocks/b": "2",
+		"/pins/a":   "3",
+		"/system/a": "4",
+	})
+
+	rs, err := d.Query(ctx, dsq.Query{Prefix: "/blocks"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectMatches(t, []string{"/blocks/a", "/blocks/b"}, rs)
+}
+
+func TestPartitionFanOutQuery(t *testing.T) {
+	ctx := context.Background()
+	d, done := newPartitionedDS(t)
+	defer done()
+
+	addTestCases(t, ctx, d, map[string]string{
+		"/blocks/a": "1",
+		"/pins/a":   "2",
+		"/system/a": "3",
+	})
+
+	rs, err := d.Query(ctx, dsq.Query{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectMatches(t, []string{"/blocks/a", "/pins/a", "/system/a"}, rs)
+}
+
+func TestPartitionDirNameNoCollision(t *testing.T) {
+	a := partitionDirName(ds.NewKey("/ab/cd").String())
+	b := partitionDirName(ds.NewKey("/ab-cd").String())
+	if a == b {
+		t.Fatalf("expected distinct directory names, got %q for both", a)
+	}
+}
+
+func TestPartitionBatch(t *testing.T) {
+	ctx := context.Background()
+	d, done := newPartitionedDS(t)
+	defer done()
+
+	b, err := d.Batch()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Put(ctx, ds.NewKey("/blocks/a"), []byte("1")); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Put(ctx, ds.NewKey("/pins/a"), []byte("2")); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Commit(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	if v, err := d.Get(ctx, ds.NewKey("/blocks/a")); err != nil || string(v) != "1" {
+		t.Fatalf("got %q, %v", v, err)
+	}
+	if v, err := d.Get(ctx, ds.NewKey("/pins/a")); err != nil || string(v) != "2" {
+		t.Fatalf("got %q, %v", v, err)
+	}
+}
@@ -0,0 +1,163 @@
+package leveldb
+
+import (
+	"context"
+	"sync"
+
+	ds "github.com/ipfs/go-datastore"
+	dsq "github.com/ipfs/go-datastore/query"
+
+	"github.com/syndtr/goleveldb/leveldb"
+)
+
+// Snapshot is a read-only, point-in-time view of a Datastore, usable for
+// Get/Has/GetSize/Query exactly as of the moment SnapshotAt was called.
+// Like a read-only Txn, it never conflicts with anything, since nothing is
+// ever committed through it; unlike one, it doesn't record a read set.
+// Callers must call Close when done with it so its
+// underlying LevelDB snapshots (and the write-log readers registered for
+// them) are released.
+type Snapshot struct {
+	ds *Datastore
+
+	mu     sync.Mutex
+	snaps  map[*leveldb.DB]*leveldb.Snapshot
+	seqs   map[*leveldb.DB]uint64
+	closed bool
+}
+
+var _ ds.Read = (*Snapshot)(nil)
+
+// SnapshotAt opens a Snapshot over every store in d (the root instance and
+// all configured Partitions) at the current point in time.
+func (d *Datastore) SnapshotAt(ctx context.Context) (*Snapshot, error) {
+	if err := d.checkCtx(ctx); err != nil {
+		return nil, err
+	}
+
+	s := &Snapshot{
+		ds:    d,
+		snaps: make(map[*leveldb.DB]*leveldb.Snapshot),
+		seqs:  make(map[*leveldb.DB]uint64),
+	}
+	for _, db := range d.allDBs() {
+		snap, err := db.GetSnapshot()
+		if err != nil {
+			s.Close()
+			return nil, err
+		}
+		s.snaps[db] = snap
+		s.seqs[db] = d.logFor(db).begin()
+	}
+	return s, nil
+}
+
+func (s *Snapshot) readerFor(db *leveldb.DB) leveldbReader {
+	return s.snaps[db]
+}
+
+// Get implements ds.Read.
+func (s *Snapshot) Get(ctx context.Context, key ds.Key) ([]byte, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return nil, ErrClosed
+	}
+	val, err := s.snaps[s.ds.partitionFor(key)].Get(key.Bytes(), nil)
+	if err != nil {
+		if err == leveldb.ErrNotFound {
+			return nil, ds.ErrNotFound
+		}
+		return nil, err
+	}
+	return val, nil
+}
+
+// Has implements ds.Read.
+func (s *Snapshot) Has(ctx context.Context, key ds.Key) (bool, error) {
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return false, ErrClosed
+	}
+	return s.snaps[s.ds.partitionFor(key)].Has(key.Bytes(), nil)
+}
+
+// GetSize implements ds.Read.
+func (s *Snapshot) GetSize(ctx context.Context, key ds.Key) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return -1, err
+	}
+	return ds.GetBackedSize(ctx, s, key)
+}
+
+// Query implements ds.Read, with the same single-partition vs fan-out
+// routing as Datastore.Query, but reading from this Snapshot's point in
+// time instead of the live store.
+func (s *Snapshot) Query(ctx context.Context, q dsq.Query) (dsq.Results, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return nil, ErrClosed
+	}
+
+	if len(s.ds.partitions) == 0 {
+		return s.ds.queryReader(ctx, s.readerFor(s.ds.db), q)
+	}
+	if target, ok := s.ds.partitionForPrefix(q.Prefix); ok {
+		db := target.(*leveldb.DB)
+		return s.ds.queryReader(ctx, s.readerFor(db), q)
+	}
+
+	var entries []dsq.Entry
+	for _, db := range s.ds.allDBs() {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		iter := s.readerFor(db).NewIterator(nil, nil)
+		for iter.Next() {
+			if err := ctx.Err(); err != nil {
+				iter.Release()
+				return nil, err
+			}
+			e := dsq.Entry{Key: string(iter.Key()), Size: len(iter.Value())}
+			if !q.KeysOnly {
+				buf := make([]byte, len(iter.Value()))
+				copy(buf, iter.Value())
+				e.Value = buf
+			}
+			entries = append(entries, e)
+		}
+		err := iter.Error()
+		iter.Release()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return dsq.NaiveQueryApply(q, dsq.ResultsWithEntries(q, entries)), nil
+}
+
+// Close releases every underlying LevelDB snapshot and unregisters this
+// Snapshot as a write-log reader. It is safe to call more than once.
+func (s *Snapshot) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return nil
+	}
+	s.closed = true
+	for db, snap := range s.snaps {
+		snap.Release()
+		s.ds.logFor(db).end(s.seqs[db])
+	}
+	return nil
+}
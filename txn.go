@@ -0,0 +1,456 @@
+package leveldb
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	ds "github.com/ipfs/go-datastore"
+	dsq "github.com/ipfs/go-datastore/query"
+
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/util"
+)
+
+// ErrReadOnly is returned by Put/Delete on a transaction opened with
+// readOnly set to true.
+var ErrReadOnly = errors.New("cannot write to a read-only transaction")
+
+// ErrConflict is returned by Commit on a read-write transaction when a key
+// or prefix range it read was written by another transaction (or a plain
+// Put/Delete/Batch) after this transaction's snapshot was taken. The
+// transaction is left uncommitted and should be retried from scratch by
+// the caller.
+var ErrConflict = errors.New("leveldb: transaction conflict, retry")
+
+// leveldbTxn gives repeatable reads for its lifetime, backed by a
+// *leveldb.Snapshot per partition it touches (opened lazily), plus, for
+// read-write transactions, optimistic concurrency control: it records
+// every key and prefix read, buffers writes locally (so the transaction
+// sees its own writes) rather than applying them immediately, and on
+// Commit holds every touched partition's write lock continuously across
+// conflict-check and apply, so nothing can write to the read set in
+// between. A conflict anywhere aborts the whole Commit with ErrConflict
+// and applies nothing. Locks are always acquired in the fixed global order
+// Datastore.dbOrder reports, so two transactions committing overlapping
+// partition sets can't deadlock by locking in opposite orders.
+//
+// Cross-partition failure mode: once all touched partitions pass their
+// conflict check, each partition's buffered writes are applied and
+// recorded one at a time; this step itself can't fail on conflict (we
+// already verified there is none), but if the underlying LevelDB write
+// fails partway through, partitions already applied stay applied. This is
+// the same non-atomic-across-partitions behavior documented on
+// leveldbBatch.
+type leveldbTxn struct {
+	ds       *Datastore
+	readOnly bool
+
+	mu     sync.Mutex
+	parts  map[*leveldb.DB]*txnPart
+	closed bool
+}
+
+type pendingOp struct {
+	deleted bool
+	value   []byte
+}
+
+// txnPart is the per-partition state of a leveldbTxn: its snapshot (for
+// repeatable reads), the write-log sequence number it was taken at (so
+// Commit knows what "since my snapshot" means), the keys/prefixes read
+// through it, and (read-write only) the writes buffered against it.
+type txnPart struct {
+	startSeq     uint64
+	snap         *leveldb.Snapshot
+	readKeys     map[string]bool
+	readPrefixes map[string]bool
+	pending      map[string]*pendingOp
+}
+
+var _ ds.Txn = (*leveldbTxn)(nil)
+
+// NewTransaction implements ds.TxnDatastore. Per-partition snapshots are
+// opened lazily as operations touch them, so opening one on an otherwise
+// idle Datastore is cheap even with many configured Partitions.
+func (d *Datastore) NewTransaction(readOnly bool) (ds.Txn, error) {
+	if err := d.checkClosed(); err != nil {
+		return nil, err
+	}
+	return &leveldbTxn{
+		ds:       d,
+		readOnly: readOnly,
+		parts:    make(map[*leveldb.DB]*txnPart),
+	}, nil
+}
+
+// partFor returns (opening if necessary) the txnPart for db. Callers must
+// hold t.mu.
+func (t *leveldbTxn) partFor(db *leveldb.DB) (*txnPart, error) {
+	if p, ok := t.parts[db]; ok {
+		return p, nil
+	}
+	// Take the snapshot and the start sequence under the commit lock, so no
+	// write can land between the two and escape conflict detection.
+	wl := t.ds.logFor(db)
+	wl.lockCommit()
+	snap, err := db.GetSnapshot()
+	if err != nil {
+		wl.unlockCommit()
+		return nil, err
+	}
+	startSeq := wl.begin()
+	wl.unlockCommit()
+	p := &txnPart{
+		startSeq:     startSeq,
+		snap:         snap,
+		readKeys:     make(map[string]bool),
+		readPrefixes: make(map[string]bool),
+	}
+	if !t.readOnly {
+		p.pending = make(map[string]*pendingOp)
+	}
+	t.parts[db] = p
+	return p, nil
+}
+
+func (t *leveldbTxn) Put(ctx context.Context, key ds.Key, value []byte) error {
+	if t.readOnly {
+		return ErrReadOnly
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.closed {
+		return ErrClosed
+	}
+	p, err := t.partFor(t.ds.partitionFor(key))
+	if err != nil {
+		return err
+	}
+	buf := make([]byte, len(value))
+	copy(buf, value)
+	p.pending[key.String()] = &pendingOp{value: buf}
+	return nil
+}
+
+func (t *leveldbTxn) Delete(ctx context.Context, key ds.Key) error {
+	if t.readOnly {
+		return ErrReadOnly
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.closed {
+		return ErrClosed
+	}
+	p, err := t.partFor(t.ds.partitionFor(key))
+	if err != nil {
+		return err
+	}
+	p.pending[key.String()] = &pendingOp{deleted: true}
+	return nil
+}
+
+func (t *leveldbTxn) Get(ctx context.Context, key ds.Key) ([]byte, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.closed {
+		return nil, ErrClosed
+	}
+	p, err := t.partFor(t.ds.partitionFor(key))
+	if err != nil {
+		return nil, err
+	}
+	ks := key.String()
+	p.readKeys[ks] = true
+
+	if op, ok := p.pending[ks]; ok {
+		if op.deleted {
+			return nil, ds.ErrNotFound
+		}
+		return op.value, nil
+	}
+
+	val, err := p.snap.Get(key.Bytes(), nil)
+	if err != nil {
+		if err == leveldb.ErrNotFound {
+			return nil, ds.ErrNotFound
+		}
+		return nil, err
+	}
+	return val, nil
+}
+
+func (t *leveldbTxn) Has(ctx context.Context, key ds.Key) (bool, error) {
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.closed {
+		return false, ErrClosed
+	}
+	p, err := t.partFor(t.ds.partitionFor(key))
+	if err != nil {
+		return false, err
+	}
+	ks := key.String()
+	p.readKeys[ks] = true
+
+	if op, ok := p.pending[ks]; ok {
+		return !op.deleted, nil
+	}
+	return p.snap.Has(key.Bytes(), nil)
+}
+
+func (t *leveldbTxn) GetSize(ctx context.Context, key ds.Key) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return -1, err
+	}
+	return ds.GetBackedSize(ctx, t, key)
+}
+
+// Query reads from each touched partition's snapshot, overlaid with this
+// transaction's own buffered writes, and records the prefix read for
+// conflict detection on Commit. Unlike Datastore.Query, results are always
+// materialized up front: buffered writes have to be merged with the
+// snapshot scan before orders/filters/offset/limit can be applied.
+func (t *leveldbTxn) Query(ctx context.Context, q dsq.Query) (dsq.Results, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.closed {
+		return nil, ErrClosed
+	}
+
+	if len(t.ds.partitions) == 0 {
+		return t.queryPartitionLocked(ctx, t.ds.db, q)
+	}
+	if target, ok := t.ds.partitionForPrefix(q.Prefix); ok {
+		return t.queryPartitionLocked(ctx, target.(*leveldb.DB), q)
+	}
+
+	dbs := make([]*leveldb.DB, 0, len(t.ds.partitions)+1)
+	dbs = append(dbs, t.ds.db)
+	for _, part := range t.ds.partitions {
+		dbs = append(dbs, part.db)
+	}
+
+	var entries []dsq.Entry
+	for _, db := range dbs {
+		es, err := t.scanPartitionLocked(ctx, db, q)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, es...)
+	}
+	return dsq.NaiveQueryApply(q, dsq.ResultsWithEntries(q, entries)), nil
+}
+
+func (t *leveldbTxn) queryPartitionLocked(ctx context.Context, db *leveldb.DB, q dsq.Query) (dsq.Results, error) {
+	entries, err := t.scanPartitionLocked(ctx, db, q)
+	if err != nil {
+		return nil, err
+	}
+	naive := q
+	naive.Prefix = ""
+	return dsq.NaiveQueryApply(naive, dsq.ResultsWithEntries(q, entries)), nil
+}
+
+// scanPartitionLocked scans db's snapshot within q.Prefix and overlays
+// this transaction's own buffered writes to that partition. Callers must
+// hold t.mu.
+func (t *leveldbTxn) scanPartitionLocked(ctx context.Context, db *leveldb.DB, q dsq.Query) ([]dsq.Entry, error) {
+	p, err := t.partFor(db)
+	if err != nil {
+		return nil, err
+	}
+
+	// Record the same normalized prefix the scan below actually covers, not
+	// the raw q.Prefix: writeLog.conflicts does a literal strings.HasPrefix
+	// against this value, so an un-normalized prefix (e.g. "a" instead of
+	// "/a") would never match the normalized keys a concurrent write
+	// records, silently missing a real conflict.
+	prefix := ds.NewKey(q.Prefix).String()
+	p.readPrefixes[prefix] = true
+
+	var rnge *util.Range
+	if q.Prefix != "" && prefix != "/" {
+		rnge = util.BytesPrefix([]byte(prefix + "/"))
+	}
+
+	seen := make(map[string]bool)
+	var out []dsq.Entry
+
+	iter := p.snap.NewIterator(rnge, nil)
+	for iter.Next() {
+		if err := ctx.Err(); err != nil {
+			iter.Release()
+			return nil, err
+		}
+		k := string(iter.Key())
+		seen[k] = true
+		if op, ok := p.pending[k]; ok {
+			if !op.deleted {
+				out = append(out, pendingEntry(k, op, q.KeysOnly))
+			}
+			continue
+		}
+		e := dsq.Entry{Key: k, Size: len(iter.Value())}
+		if !q.KeysOnly {
+			buf := make([]byte, len(iter.Value()))
+			copy(buf, iter.Value())
+			e.Value = buf
+		}
+		out = append(out, e)
+	}
+	ierr := iter.Error()
+	iter.Release()
+	if ierr != nil {
+		return nil, ierr
+	}
+
+	// Buffered writes that fall within the prefix but weren't already
+	// reflected in the snapshot scan (new keys this txn created).
+	for k, op := range p.pending {
+		if seen[k] || op.deleted {
+			continue
+		}
+		if prefix != "/" && !hasDSPrefix(k, prefix) {
+			continue
+		}
+		out = append(out, pendingEntry(k, op, q.KeysOnly))
+	}
+	return out, nil
+}
+
+func pendingEntry(key string, op *pendingOp, keysOnly bool) dsq.Entry {
+	e := dsq.Entry{Key: key, Size: len(op.value)}
+	if !keysOnly {
+		e.Value = op.value
+	}
+	return e
+}
+
+func hasDSPrefix(key, prefix string) bool {
+	return key == prefix || len(key) > len(prefix) && key[:len(prefix)+1] == prefix+"/"
+}
+
+// txnCommitPart pairs a touched partition's pending writes with the
+// writeLog that guards it, for the duration of Commit's locked section.
+type txnCommitPart struct {
+	db *leveldb.DB
+	p  *txnPart
+	wl *writeLog
+}
+
+// Commit locks every touched partition's write log up front (in the fixed
+// global order Datastore.dbOrder reports, to avoid deadlocking against
+// another transaction locking the same set), then checks all of them for
+// conflicts before applying anything: if any partition conflicts, Commit
+// aborts entirely with ErrConflict and nothing is applied anywhere.
+// Otherwise each partition's buffered writes are applied and recorded
+// while every lock is still held, so no write can land in the read set
+// between the check and the apply. Every snapshot is released regardless
+// of outcome.
+func (t *leveldbTxn) Commit(ctx context.Context) (err error) {
+	defer t.ds.track("transaction", time.Now(), &err, -1, -1)
+	if err = ctx.Err(); err != nil {
+		return err
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.closed {
+		return ErrClosed
+	}
+	defer t.closeLocked()
+
+	if t.readOnly {
+		return nil
+	}
+
+	parts := make([]txnCommitPart, 0, len(t.parts))
+	for db, p := range t.parts {
+		parts = append(parts, txnCommitPart{db: db, p: p, wl: t.ds.logFor(db)})
+	}
+	order := t.ds.dbOrder()
+	sort.Slice(parts, func(i, j int) bool { return order[parts[i].db] < order[parts[j].db] })
+
+	for _, pt := range parts {
+		pt.wl.lockCommit()
+	}
+	defer func() {
+		for _, pt := range parts {
+			pt.wl.unlockCommit()
+		}
+	}()
+
+	for _, pt := range parts {
+		if pt.wl.conflicts(pt.p.startSeq, pt.p.readKeys, pt.p.readPrefixes) {
+			return ErrConflict
+		}
+	}
+
+	for _, pt := range parts {
+		if len(pt.p.pending) == 0 {
+			continue
+		}
+		batch := new(leveldb.Batch)
+		keys := make([]string, 0, len(pt.p.pending))
+		for k, op := range pt.p.pending {
+			if op.deleted {
+				batch.Delete([]byte(k))
+			} else {
+				batch.Put([]byte(k), op.value)
+			}
+			keys = append(keys, k)
+		}
+		if werr := pt.db.Write(batch, nil); werr != nil {
+			err = werr
+			break
+		}
+		pt.wl.record(keys)
+	}
+
+	return err
+}
+
+// Discard releases every snapshot this transaction opened, abandoning any
+// buffered writes.
+func (t *leveldbTxn) Discard(ctx context.Context) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.closed {
+		return
+	}
+	t.closeLocked()
+}
+
+// closeLocked releases every open snapshot and unregisters this
+// transaction's readers from each partition's write log. Callers must hold
+// t.mu.
+func (t *leveldbTxn) closeLocked() {
+	t.closed = true
+	for db, p := range t.parts {
+		p.snap.Release()
+		t.ds.logFor(db).end(p.startSeq)
+	}
+}
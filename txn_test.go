@@ -0,0 +1,206 @@
+package leveldb
+
+import (
+	"context"
+	"testing"
+
+	ds "github.com/ipfs/go-datastore"
+	dsq "github.com/ipfs/go-datastore/query"
+)
+
+// TestTransactionSnapshotIsolation checks that a transaction's reads stay
+// fixed to the state as of its first read, even after another writer
+// commits a change to the same key.
+func TestTransactionSnapshotIsolation(t *testing.T) {
+	d, done := newDSMem(t)
+	defer done()
+	ctx := context.Background()
+
+	key := ds.NewKey("/a")
+	if err := d.Put(ctx, key, []byte("before")); err != nil {
+		t.Fatal(err)
+	}
+
+	txn, err := d.NewTransaction(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer txn.Discard(ctx)
+
+	if val, err := txn.Get(ctx, key); err != nil || string(val) != "before" {
+		t.Fatalf("expected %q, got %q, %v", "before", val, err)
+	}
+
+	if err := d.Put(ctx, key, []byte("after")); err != nil {
+		t.Fatal(err)
+	}
+
+	if val, err := txn.Get(ctx, key); err != nil || string(val) != "before" {
+		t.Fatalf("txn read changed after external write: got %q, %v", val, err)
+	}
+
+	if val, err := d.Get(ctx, key); err != nil || string(val) != "after" {
+		t.Fatalf("expected external read to see %q, got %q, %v", "after", val, err)
+	}
+}
+
+// TestTransactionConflictDetection checks that two concurrent read-write
+// transactions that both read and then write the same key result in
+// ErrConflict for whichever commits second.
+func TestTransactionConflictDetection(t *testing.T) {
+	d, done := newDSMem(t)
+	defer done()
+	ctx := context.Background()
+
+	key := ds.NewKey("/counter")
+	if err := d.Put(ctx, key, []byte("0")); err != nil {
+		t.Fatal(err)
+	}
+
+	txn1, err := d.NewTransaction(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	txn2, err := d.NewTransaction(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := txn1.Get(ctx, key); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := txn2.Get(ctx, key); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := txn1.Put(ctx, key, []byte("1")); err != nil {
+		t.Fatal(err)
+	}
+	if err := txn2.Put(ctx, key, []byte("2")); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := txn1.Commit(ctx); err != nil {
+		t.Fatalf("first commit should succeed, got %v", err)
+	}
+	if err := txn2.Commit(ctx); err != ErrConflict {
+		t.Fatalf("second commit should conflict, got %v", err)
+	}
+
+	val, err := d.Get(ctx, key)
+	if err != nil || string(val) != "1" {
+		t.Fatalf("expected committed value %q, got %q, %v", "1", val, err)
+	}
+}
+
+// TestTransactionConflictDetectionUnnormalizedPrefix checks that a Query
+// with a Prefix not already in canonical ds.Key form (no leading "/") still
+// registers a read-range conflict against a concurrent write that falls
+// inside it, i.e. the read set is tracked in the same normalized key space
+// the scan itself covers.
+func TestTransactionConflictDetectionUnnormalizedPrefix(t *testing.T) {
+	d, done := newDSMem(t)
+	defer done()
+	ctx := context.Background()
+
+	if err := d.Put(ctx, ds.NewKey("/a/1"), []byte("1")); err != nil {
+		t.Fatal(err)
+	}
+
+	txn, err := d.NewTransaction(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := txn.Query(ctx, dsq.Query{Prefix: "a"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := d.Put(ctx, ds.NewKey("/a/2"), []byte("2")); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := txn.Put(ctx, ds.NewKey("/unrelated"), []byte("x")); err != nil {
+		t.Fatal(err)
+	}
+	if err := txn.Commit(ctx); err != ErrConflict {
+		t.Fatalf("expected commit to conflict with the concurrent write inside the scanned prefix, got %v", err)
+	}
+}
+
+// TestTransactionNoConflictDisjointKeys checks that two concurrent
+// transactions touching different keys can both commit.
+func TestTransactionNoConflictDisjointKeys(t *testing.T) {
+	d, done := newDSMem(t)
+	defer done()
+	ctx := context.Background()
+
+	keyA := ds.NewKey("/a")
+	keyB := ds.NewKey("/b")
+
+	txn1, err := d.NewTransaction(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	txn2, err := d.NewTransaction(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := txn1.Put(ctx, keyA, []byte("1")); err != nil {
+		t.Fatal(err)
+	}
+	if err := txn2.Put(ctx, keyB, []byte("2")); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := txn1.Commit(ctx); err != nil {
+		t.Fatalf("txn1 commit: %v", err)
+	}
+	if err := txn2.Commit(ctx); err != nil {
+		t.Fatalf("txn2 commit: %v", err)
+	}
+}
+
+// TestTransactionCleanupOnDiscard checks that Discard releases the
+// transaction's snapshot registration so it no longer holds back write log
+// pruning, and that operations on a discarded transaction fail.
+func TestTransactionCleanupOnDiscard(t *testing.T) {
+	d, done := newDSMem(t)
+	defer done()
+	ctx := context.Background()
+	key := ds.NewKey("/a")
+
+	txn, err := d.NewTransaction(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := txn.Get(ctx, key); err != ds.ErrNotFound {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+
+	lt := txn.(*leveldbTxn)
+	lt.mu.Lock()
+	if len(lt.parts) != 1 {
+		lt.mu.Unlock()
+		t.Fatalf("expected exactly one snapshot opened, got %d", len(lt.parts))
+	}
+	var startSeq uint64
+	for _, p := range lt.parts {
+		startSeq = p.startSeq
+	}
+	lt.mu.Unlock()
+
+	if n := d.rootLog.active[startSeq]; n != 1 {
+		t.Fatalf("expected reader registered in write log, got count %d", n)
+	}
+
+	txn.Discard(ctx)
+
+	if n := d.rootLog.active[startSeq]; n != 0 {
+		t.Fatalf("expected reader unregistered after Discard, got count %d", n)
+	}
+	if _, err := txn.Get(ctx, key); err != ErrClosed {
+		t.Fatalf("expected ErrClosed on discarded transaction, got %v", err)
+	}
+}
@@ -0,0 +1,120 @@
+package leveldb
+
+import (
+	"strings"
+	"sync"
+)
+
+// writeLog is a lightweight, per-partition commit log used to give
+// snapshot-isolated transactions optimistic concurrency control: every
+// committed write is recorded here with the sequence number it landed at,
+// and a transaction's Commit can ask "has anything I read changed since my
+// snapshot was taken?" without LevelDB itself knowing anything about
+// transactions.
+//
+// commitMu serializes "check for conflicts, apply the write, record it" as
+// a single atomic step across everything writing to this partition (plain
+// Put/Delete/Batch as well as txn Commit), which is the "write lock" that
+// read-set conflict checking re-checks under. mu is a separate, finer
+// grained lock protecting the bookkeeping (seq/active/entries) so readers
+// registering via begin don't have to wait on an in-flight commit.
+type writeLog struct {
+	commitMu sync.Mutex
+
+	mu      sync.Mutex
+	seq     uint64
+	active  map[uint64]int // startSeq -> number of open readers at that seq
+	entries []writeLogEntry
+}
+
+type writeLogEntry struct {
+	seq uint64
+	key string
+}
+
+func newWriteLog() *writeLog {
+	return &writeLog{active: make(map[uint64]int)}
+}
+
+// begin registers a new reader as of the current sequence number and
+// returns it; the reader must call end with the same value once it's done
+// (committed, discarded, or closed) so old entries can be pruned.
+func (w *writeLog) begin() uint64 {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	s := w.seq
+	w.active[s]++
+	return s
+}
+
+func (w *writeLog) end(startSeq uint64) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.active[startSeq]--
+	if w.active[startSeq] <= 0 {
+		delete(w.active, startSeq)
+	}
+	w.pruneLocked()
+}
+
+// lockCommit and unlockCommit bracket a single write (or a single
+// transaction's per-partition commit): conflict-check, apply, record.
+func (w *writeLog) lockCommit()   { w.commitMu.Lock() }
+func (w *writeLog) unlockCommit() { w.commitMu.Unlock() }
+
+// conflicts reports whether any key has been written at a sequence number
+// greater than since, where the key either appears in keys or starts with
+// one of prefixes (an empty prefix matches everything, for prefix-less
+// queries). Callers must hold commitMu.
+func (w *writeLog) conflicts(since uint64, keys map[string]bool, prefixes map[string]bool) bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for _, e := range w.entries {
+		if e.seq <= since {
+			continue
+		}
+		if keys[e.key] {
+			return true
+		}
+		for pfx := range prefixes {
+			if pfx == "" || strings.HasPrefix(e.key, pfx) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// record appends keys as committed at a new sequence number and returns
+// it. Callers must hold commitMu.
+func (w *writeLog) record(keys []string) uint64 {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.seq++
+	s := w.seq
+	for _, k := range keys {
+		w.entries = append(w.entries, writeLogEntry{seq: s, key: k})
+	}
+	w.pruneLocked()
+	return s
+}
+
+// pruneLocked drops entries no open reader can still need. Callers must
+// hold mu.
+func (w *writeLog) pruneLocked() {
+	min := w.seq
+	for s := range w.active {
+		if s < min {
+			min = s
+		}
+	}
+	i := 0
+	for ; i < len(w.entries); i++ {
+		if w.entries[i].seq > min {
+			break
+		}
+	}
+	if i > 0 {
+		w.entries = append([]writeLogEntry(nil), w.entries[i:]...)
+	}
+}